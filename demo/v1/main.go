@@ -1,60 +1,561 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// defaultRequestTimeout is the RequestTimeout used when an InventoryService
+// is constructed via NewInventoryService. It bounds how long an HTTP
+// handler will wait to acquire the service mutex before giving up on a
+// slow or contended request.
+const defaultRequestTimeout = 5 * time.Second
+
+// snapshotCtxCheckInterval is how often GetInventorySnapshot checks ctx.Err()
+// while replaying the event log, so a slow client disconnecting doesn't tie
+// up the server once the log grows large.
+const snapshotCtxCheckInterval = 1024
+
 // InventoryItem represents an item in the inventory.
 type InventoryItem struct {
 	ItemID      string
 	WarehouseID string
 	Count       int
+	Version     uint64
 	Holds       map[string]Hold
 	Mutex       sync.Mutex
 }
 
+// inventoryItemWire is the stable on-disk/wire representation of an
+// InventoryItem. It excludes Mutex, which carries unexported runtime state
+// gob cannot encode.
+type inventoryItemWire struct {
+	ItemID      string
+	WarehouseID string
+	Count       int
+	Version     uint64
+	Holds       map[string]Hold
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler so InventoryItem has a
+// stable on-disk format independent of its unexported Mutex field. It takes
+// a pointer receiver so gob-encoding an InventoryItem never copies its
+// embedded Mutex.
+func (i *InventoryItem) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	wire := inventoryItemWire{
+		ItemID:      i.ItemID,
+		WarehouseID: i.WarehouseID,
+		Count:       i.Count,
+		Version:     i.Version,
+		Holds:       i.Holds,
+	}
+	if err := gob.NewEncoder(&buf).Encode(wire); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, the counterpart to
+// MarshalBinary.
+func (i *InventoryItem) UnmarshalBinary(data []byte) error {
+	var wire inventoryItemWire
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wire); err != nil {
+		return err
+	}
+	i.ItemID = wire.ItemID
+	i.WarehouseID = wire.WarehouseID
+	i.Count = wire.Count
+	i.Version = wire.Version
+	i.Holds = wire.Holds
+	return nil
+}
+
 // Hold represents a hold placed on an inventory item.
 type Hold struct {
 	HoldID    string
 	Amount    int
 	Timestamp time.Time
+	ExpiresAt time.Time
+	TTL       time.Duration
 }
 
+// copyHolds returns a shallow copy of holds. Hold is a plain value type, so
+// copying the map is enough to give the result an independent point-in-time
+// view that won't keep changing as the original is mutated.
+func copyHolds(holds map[string]Hold) map[string]Hold {
+	copied := make(map[string]Hold, len(holds))
+	for id, hold := range holds {
+		copied[id] = hold
+	}
+	return copied
+}
+
+// Event types recorded in the event log.
+const (
+	EventTypeHoldPlaced    = "hold_placed"
+	EventTypeHoldExecuted  = "hold_executed"
+	EventTypeHoldExpired   = "hold_expired"
+	EventTypeCountAdjusted = "count_adjusted"
+)
+
 // Event represents an inventory change event for event sourcing.
 type Event struct {
+	EventID     string    `json:"event_id"`
+	Type        string    `json:"type"`
+	ItemID      string    `json:"item_id"`
+	WarehouseID string    `json:"warehouse_id"`
+	Amount      int       `json:"amount"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// eventWire is Event's on-disk/wire representation. It's a distinct type
+// (rather than Event itself) so gob's dispatch to MarshalBinary doesn't
+// recurse into Event's own implementation.
+type eventWire struct {
 	EventID     string
+	Type        string
 	ItemID      string
 	WarehouseID string
 	Amount      int
 	Timestamp   time.Time
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler so Event has a stable
+// on-disk format when persisted by a SnapshotStore.
+func (e Event) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	wire := eventWire{
+		EventID:     e.EventID,
+		Type:        e.Type,
+		ItemID:      e.ItemID,
+		WarehouseID: e.WarehouseID,
+		Amount:      e.Amount,
+		Timestamp:   e.Timestamp,
+	}
+	if err := gob.NewEncoder(&buf).Encode(wire); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, the counterpart to
+// MarshalBinary.
+func (e *Event) UnmarshalBinary(data []byte) error {
+	var wire eventWire
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wire); err != nil {
+		return err
+	}
+	e.EventID = wire.EventID
+	e.Type = wire.Type
+	e.ItemID = wire.ItemID
+	e.WarehouseID = wire.WarehouseID
+	e.Amount = wire.Amount
+	e.Timestamp = wire.Timestamp
+	return nil
+}
+
+// DefaultHoldTTL is the hold lifetime used when PlaceHold is not given an
+// explicit TTL.
+const DefaultHoldTTL = 5 * time.Minute
+
+// Checkpoint is a point-in-time compaction of InventoryService's per-key
+// item state, used so GetInventorySnapshot can skip replaying events
+// already folded into it.
+type Checkpoint struct {
+	// Timestamp is the timestamp of the last event folded into Items; a
+	// snapshot at or after Timestamp needs no event replay to include it.
+	Timestamp time.Time
+	Items     map[string]inventoryItemWire
+}
+
+// snapshotRecordKind distinguishes the two record types a SnapshotStore
+// writes to its backend.
+type snapshotRecordKind uint8
+
+const (
+	snapshotRecordCheckpoint snapshotRecordKind = iota
+	snapshotRecordEvent
+)
+
+// snapshotRecord is the on-disk envelope for a SnapshotStore backend: either
+// a full checkpoint or a single event appended to the tail since the most
+// recent checkpoint.
+type snapshotRecord struct {
+	Kind       snapshotRecordKind
+	Checkpoint Checkpoint
+	Event      Event
+}
+
+// SnapshotStore periodically checkpoints InventoryService state to a
+// pluggable io.Writer-backed backend (e.g. a file) using encoding/gob. It
+// also logs every event appended since the last checkpoint, so Load can
+// reconstruct the latest checkpoint plus its tail without needing the full
+// event history.
+type SnapshotStore struct {
+	mu      sync.Mutex
+	backend io.Writer
+	enc     *gob.Encoder
+
+	checkpoints []Checkpoint
+}
+
+// NewSnapshotStore creates a SnapshotStore that appends checkpoints and
+// events to backend as they're written.
+func NewSnapshotStore(backend io.Writer) *SnapshotStore {
+	return &SnapshotStore{backend: backend, enc: gob.NewEncoder(backend)}
+}
+
+// Save persists a checkpoint to the backend and remembers it for Latest.
+func (st *SnapshotStore) Save(cp Checkpoint) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.enc.Encode(snapshotRecord{Kind: snapshotRecordCheckpoint, Checkpoint: cp}); err != nil {
+		return err
+	}
+	st.checkpoints = append(st.checkpoints, cp)
+	return nil
+}
+
+// AppendEvent persists a single tail event to the backend, to be replayed
+// after the most recent checkpoint on the next Load.
+func (st *SnapshotStore) AppendEvent(e Event) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.enc.Encode(snapshotRecord{Kind: snapshotRecordEvent, Event: e})
+}
+
+// Latest returns the most recent checkpoint with Timestamp <= at, if any.
+func (st *SnapshotStore) Latest(at time.Time) (Checkpoint, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var best Checkpoint
+	found := false
+	for _, cp := range st.checkpoints {
+		if cp.Timestamp.After(at) {
+			continue
+		}
+		if !found || cp.Timestamp.After(best.Timestamp) {
+			best = cp
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Load replays every record previously written to r, rebuilding the
+// in-memory checkpoint history and returning the most recent checkpoint
+// along with the events appended after it.
+func (st *SnapshotStore) Load(r io.Reader) (Checkpoint, []Event, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	dec := gob.NewDecoder(r)
+	var latest Checkpoint
+	var tail []Event
+	checkpoints := make([]Checkpoint, 0)
+
+	for {
+		var rec snapshotRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return Checkpoint{}, nil, err
+		}
+
+		switch rec.Kind {
+		case snapshotRecordCheckpoint:
+			checkpoints = append(checkpoints, rec.Checkpoint)
+			latest = rec.Checkpoint
+			tail = tail[:0]
+		case snapshotRecordEvent:
+			tail = append(tail, rec.Event)
+		}
+	}
+
+	st.checkpoints = checkpoints
+	return latest, tail, nil
+}
+
+// Metrics holds the Prometheus collectors InventoryService updates on every
+// hold mutation. A nil *Metrics (the default) leaves the service
+// uninstrumented so it works standalone without a registry.
+type Metrics struct {
+	HoldsPlaced    *prometheus.CounterVec
+	HoldsExecuted  *prometheus.CounterVec
+	HoldsExpired   *prometheus.CounterVec
+	InventoryCount *prometheus.GaugeVec
+	ActiveHolds    *prometheus.GaugeVec
+}
+
+// NewMetrics creates a Metrics struct and registers its collectors against
+// registry.
+func NewMetrics(registry *prometheus.Registry) *Metrics {
+	labels := []string{"item_id", "warehouse_id"}
+	m := &Metrics{
+		HoldsPlaced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "holds_placed_total",
+			Help: "Total number of holds placed, labeled by item and warehouse.",
+		}, labels),
+		HoldsExecuted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "holds_executed_total",
+			Help: "Total number of holds executed, labeled by item and warehouse.",
+		}, labels),
+		HoldsExpired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "holds_expired_total",
+			Help: "Total number of holds reclaimed by expiration, labeled by item and warehouse.",
+		}, labels),
+		InventoryCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "inventory_count",
+			Help: "Current available inventory count, labeled by item and warehouse.",
+		}, labels),
+		ActiveHolds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "active_holds",
+			Help: "Current number of active holds, labeled by item and warehouse.",
+		}, labels),
+	}
+	registry.MustRegister(m.HoldsPlaced, m.HoldsExecuted, m.HoldsExpired, m.InventoryCount, m.ActiveHolds)
+	return m
+}
+
 // InventoryService manages inventory items.
 type InventoryService struct {
 	Items  map[string]*InventoryItem
 	Events []Event
 	Mutex  sync.Mutex
+
+	// DefaultHoldTTL is the fallback hold lifetime for PlaceHold calls that
+	// don't specify one.
+	DefaultHoldTTL time.Duration
+
+	// RequestTimeout bounds how long an HTTP handler will wait to acquire
+	// the service mutex before giving up on a slow or contended request.
+	RequestTimeout time.Duration
+
+	// Snapshots, if set via EnableSnapshots, receives periodic checkpoints
+	// of Items so GetInventorySnapshot doesn't have to replay the full
+	// event log once it runs for a long time.
+	Snapshots *SnapshotStore
+
+	// CheckpointEveryEvents and CheckpointInterval control how often a
+	// checkpoint is taken; a value of zero disables that trigger. At least
+	// one must be positive for Snapshots to ever checkpoint.
+	CheckpointEveryEvents int
+	CheckpointInterval    time.Duration
+
+	eventsSinceCheckpoint int
+	lastCheckpointAt      time.Time
+
+	// Metrics, if set via EnableMetrics, is updated with counters and
+	// gauges on every hold mutation.
+	Metrics *Metrics
+
+	// eventHorizon is the timestamp of the most recent checkpoint compaction
+	// (from either checkpoint() or Restore()). Events is only complete back
+	// to this point; anything older was folded into a checkpoint and is no
+	// longer retained. A zero value means no compaction has happened and
+	// Events holds the full history.
+	eventHorizon time.Time
+
+	// seenEventIDs dedups events recorded locally and events applied via
+	// ApplyEvents, so replaying a sync batch twice is a no-op.
+	seenEventIDs map[string]struct{}
 }
 
 // NewInventoryService creates a new InventoryService.
 func NewInventoryService() *InventoryService {
 	return &InventoryService{
-		Items:  make(map[string]*InventoryItem),
-		Events: make([]Event, 0),
+		Items:          make(map[string]*InventoryItem),
+		Events:         make([]Event, 0),
+		DefaultHoldTTL: DefaultHoldTTL,
+		RequestTimeout: defaultRequestTimeout,
+		seenEventIDs:   make(map[string]struct{}),
 	}
 }
 
-// PlaceHold places a hold on an inventory item.
-func (s *InventoryService) PlaceHold(itemID, warehouseID string, amount int) (string, error) {
+// EnableSnapshots configures periodic checkpointing against store. A
+// checkpoint is taken once eventsSinceCheckpoint reaches everyEvents (if
+// positive) or once interval has elapsed since the last checkpoint (if
+// positive).
+func (s *InventoryService) EnableSnapshots(store *SnapshotStore, everyEvents int, interval time.Duration) {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
 
+	s.Snapshots = store
+	s.CheckpointEveryEvents = everyEvents
+	s.CheckpointInterval = interval
+	s.lastCheckpointAt = time.Now()
+}
+
+// EnableMetrics configures m to be updated on every hold mutation.
+func (s *InventoryService) EnableMetrics(m *Metrics) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.Metrics = m
+}
+
+// acquireMutex locks s.Mutex, returning ctx.Err() instead if ctx is
+// cancelled or its deadline expires first. If ctx wins the race, a
+// background goroutine still waits for the lock and releases it the moment
+// it's acquired, so the mutex is never left held by an abandoned caller.
+func (s *InventoryService) acquireMutex(ctx context.Context) error {
+	acquired := make(chan struct{})
+	go func() {
+		s.Mutex.Lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			s.Mutex.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// recordEvent appends an event to the log, deduping by EventID. The caller
+// must hold s.Mutex. It reports whether the event was new.
+func (s *InventoryService) recordEvent(event Event) bool {
+	if _, seen := s.seenEventIDs[event.EventID]; seen {
+		return false
+	}
+	s.Events = append(s.Events, event)
+	s.seenEventIDs[event.EventID] = struct{}{}
+
+	if s.Snapshots != nil {
+		if err := s.Snapshots.AppendEvent(event); err != nil {
+			fmt.Printf("snapshot store: failed to append event %s: %v\n", event.EventID, err)
+		}
+		s.eventsSinceCheckpoint++
+		s.maybeCheckpoint()
+	}
+
+	return true
+}
+
+// maybeCheckpoint checkpoints the service if CheckpointEveryEvents events
+// have accumulated or CheckpointInterval has elapsed since the last
+// checkpoint. The caller must hold s.Mutex.
+func (s *InventoryService) maybeCheckpoint() {
+	dueByCount := s.CheckpointEveryEvents > 0 && s.eventsSinceCheckpoint >= s.CheckpointEveryEvents
+	dueByTime := s.CheckpointInterval > 0 && time.Since(s.lastCheckpointAt) >= s.CheckpointInterval
+	if !dueByCount && !dueByTime {
+		return
+	}
+
+	if err := s.checkpoint(); err != nil {
+		fmt.Printf("snapshot store: checkpoint failed: %v\n", err)
+	}
+}
+
+// checkpoint captures the current per-key item state, persists it via
+// Snapshots, and compacts Events down to the (now empty) tail after the
+// checkpoint. The caller must hold s.Mutex.
+func (s *InventoryService) checkpoint() error {
+	items := make(map[string]inventoryItemWire, len(s.Items))
+	for key, item := range s.Items {
+		item.Mutex.Lock()
+		items[key] = inventoryItemWire{
+			ItemID:      item.ItemID,
+			WarehouseID: item.WarehouseID,
+			Count:       item.Count,
+			Version:     item.Version,
+			Holds:       copyHolds(item.Holds),
+		}
+		item.Mutex.Unlock()
+	}
+
+	timestamp := time.Now()
+	if n := len(s.Events); n > 0 {
+		timestamp = s.Events[n-1].Timestamp
+	}
+
+	if err := s.Snapshots.Save(Checkpoint{Timestamp: timestamp, Items: items}); err != nil {
+		return err
+	}
+
+	s.Events = s.Events[:0]
+	s.eventsSinceCheckpoint = 0
+	s.lastCheckpointAt = time.Now()
+	s.eventHorizon = timestamp
+	return nil
+}
+
+// Restore seeds Items and Events from the most recent checkpoint found in r
+// plus the tail of events appended after it, via s.Snapshots. It should be
+// called once at startup, before the service accepts requests.
+func (s *InventoryService) Restore(r io.Reader) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.Snapshots == nil {
+		return fmt.Errorf("no snapshot store configured")
+	}
+
+	checkpoint, tail, err := s.Snapshots.Load(r)
+	if err != nil {
+		return err
+	}
+
+	for key, item := range checkpoint.Items {
+		stored, exists := s.Items[key]
+		if !exists {
+			stored = &InventoryItem{ItemID: item.ItemID, WarehouseID: item.WarehouseID, Holds: make(map[string]Hold)}
+			s.Items[key] = stored
+		}
+		stored.Mutex.Lock()
+		stored.Count = item.Count
+		stored.Version = item.Version
+		if item.Holds != nil {
+			stored.Holds = item.Holds
+		}
+		stored.Mutex.Unlock()
+	}
+
+	s.Events = make([]Event, 0, len(tail))
+	for _, event := range tail {
+		s.Events = append(s.Events, event)
+		s.seenEventIDs[event.EventID] = struct{}{}
+	}
+	s.eventsSinceCheckpoint = len(tail)
+	s.lastCheckpointAt = time.Now()
+	s.eventHorizon = checkpoint.Timestamp
+
+	return nil
+}
+
+// PlaceHold places a hold on an inventory item. ttl controls how long the
+// hold is valid before ReleaseExpiredHolds reclaims it; if ttl is zero, the
+// service's DefaultHoldTTL is used.
+func (s *InventoryService) PlaceHold(ctx context.Context, itemID, warehouseID string, amount int, ttl time.Duration) (string, error) {
+	if err := s.acquireMutex(ctx); err != nil {
+		return "", err
+	}
+	defer s.Mutex.Unlock()
+
 	key := fmt.Sprintf("%s:%s", itemID, warehouseID)
 	item, exists := s.Items[key]
 	if !exists {
@@ -68,31 +569,48 @@ func (s *InventoryService) PlaceHold(itemID, warehouseID string, amount int) (st
 		return "", fmt.Errorf("insufficient inventory")
 	}
 
+	if ttl <= 0 {
+		ttl = s.DefaultHoldTTL
+	}
+
 	holdID := uuid.New().String()
+	now := time.Now()
 	hold := Hold{
 		HoldID:    holdID,
 		Amount:    amount,
-		Timestamp: time.Now(),
+		Timestamp: now,
+		ExpiresAt: now.Add(ttl),
+		TTL:       ttl,
 	}
 	item.Count -= amount
+	item.Version++
 	item.Holds[holdID] = hold
 
+	if s.Metrics != nil {
+		s.Metrics.HoldsPlaced.WithLabelValues(itemID, warehouseID).Inc()
+		s.Metrics.InventoryCount.WithLabelValues(itemID, warehouseID).Set(float64(item.Count))
+		s.Metrics.ActiveHolds.WithLabelValues(itemID, warehouseID).Set(float64(len(item.Holds)))
+	}
+
 	// Record the event
 	event := Event{
 		EventID:     uuid.New().String(),
+		Type:        EventTypeHoldPlaced,
 		ItemID:      itemID,
 		WarehouseID: warehouseID,
 		Amount:      -amount,
-		Timestamp:   time.Now(),
+		Timestamp:   now,
 	}
-	s.Events = append(s.Events, event)
+	s.recordEvent(event)
 
 	return holdID, nil
 }
 
 // ExecuteHold finalizes a hold on an inventory item.
-func (s *InventoryService) ExecuteHold(itemID, warehouseID, holdID string) error {
-	s.Mutex.Lock()
+func (s *InventoryService) ExecuteHold(ctx context.Context, itemID, warehouseID, holdID string) error {
+	if err := s.acquireMutex(ctx); err != nil {
+		return err
+	}
 	defer s.Mutex.Unlock()
 
 	key := fmt.Sprintf("%s:%s", itemID, warehouseID)
@@ -104,7 +622,7 @@ func (s *InventoryService) ExecuteHold(itemID, warehouseID, holdID string) error
 	item.Mutex.Lock()
 	defer item.Mutex.Unlock()
 
-	_, exists = item.Holds[holdID]
+	hold, exists := item.Holds[holdID]
 	if !exists {
 		return fmt.Errorf("hold not found")
 	}
@@ -112,69 +630,399 @@ func (s *InventoryService) ExecuteHold(itemID, warehouseID, holdID string) error
 	// Remove the hold
 	delete(item.Holds, holdID)
 
-	// Optionally, log that the hold was executed
-	// For this demo, we'll assume the hold was executed successfully
+	if s.Metrics != nil {
+		s.Metrics.HoldsExecuted.WithLabelValues(itemID, warehouseID).Inc()
+		s.Metrics.ActiveHolds.WithLabelValues(itemID, warehouseID).Set(float64(len(item.Holds)))
+	}
+
+	// Record the event
+	event := Event{
+		EventID:     uuid.New().String(),
+		Type:        EventTypeHoldExecuted,
+		ItemID:      itemID,
+		WarehouseID: warehouseID,
+		Amount:      hold.Amount,
+		Timestamp:   time.Now(),
+	}
+	s.recordEvent(event)
 
 	return nil
 }
 
-// ReleaseExpiredHolds releases holds that have expired.
-// This can be run periodically to release holds that have not been executed within a certain time frame
-// returning the reserved inventory back to the available count.
-func (s *InventoryService) ReleaseExpiredHolds(expirationDuration time.Duration) {
-	s.Mutex.Lock()
+// RefreshHold extends a still-active hold's lifetime by resetting its
+// ExpiresAt to now + the hold's TTL, letting a client keep a reservation
+// alive across a long-running checkout instead of racing the original
+// expiration.
+func (s *InventoryService) RefreshHold(ctx context.Context, itemID, warehouseID, holdID string) error {
+	if err := s.acquireMutex(ctx); err != nil {
+		return err
+	}
+	defer s.Mutex.Unlock()
+
+	key := fmt.Sprintf("%s:%s", itemID, warehouseID)
+	item, exists := s.Items[key]
+	if !exists {
+		return fmt.Errorf("item not found")
+	}
+
+	item.Mutex.Lock()
+	defer item.Mutex.Unlock()
+
+	hold, exists := item.Holds[holdID]
+	if !exists {
+		return fmt.Errorf("hold not found")
+	}
+
+	hold.Timestamp = time.Now()
+	hold.ExpiresAt = hold.Timestamp.Add(hold.TTL)
+	item.Holds[holdID] = hold
+
+	return nil
+}
+
+// ReleaseExpiredHolds reclaims any hold whose ExpiresAt has passed.
+// This can be run periodically to release holds that have not been executed
+// or refreshed in time, returning the reserved inventory back to the
+// available count.
+func (s *InventoryService) ReleaseExpiredHolds(ctx context.Context) error {
+	if err := s.acquireMutex(ctx); err != nil {
+		return err
+	}
 	defer s.Mutex.Unlock()
 
 	now := time.Now()
 	for _, item := range s.Items {
 		item.Mutex.Lock()
 		for holdID, hold := range item.Holds {
-			if now.Sub(hold.Timestamp) > expirationDuration {
-				// Release the hold
+			if now.After(hold.ExpiresAt) {
+				// Reclaim the hold
 				item.Count += hold.Amount
+				item.Version++
 				delete(item.Holds, holdID)
 
+				if s.Metrics != nil {
+					s.Metrics.HoldsExpired.WithLabelValues(item.ItemID, item.WarehouseID).Inc()
+					s.Metrics.InventoryCount.WithLabelValues(item.ItemID, item.WarehouseID).Set(float64(item.Count))
+					s.Metrics.ActiveHolds.WithLabelValues(item.ItemID, item.WarehouseID).Set(float64(len(item.Holds)))
+				}
+
 				// Record the event
 				event := Event{
 					EventID:     uuid.New().String(),
+					Type:        EventTypeHoldExpired,
 					ItemID:      item.ItemID,
 					WarehouseID: item.WarehouseID,
 					Amount:      hold.Amount,
 					Timestamp:   time.Now(),
 				}
-				s.Events = append(s.Events, event)
+				s.recordEvent(event)
 			}
 		}
 		item.Mutex.Unlock()
 	}
+
+	return nil
 }
 
-// GetInventorySnapshot generates a snapshot of the inventory at a specific timestamp.
-func (s *InventoryService) GetInventorySnapshot(at time.Time) map[string]int {
-	s.Mutex.Lock()
+// GetInventorySnapshot generates a snapshot of the inventory at a specific
+// timestamp. If a checkpoint covers part of the history (Snapshots is
+// configured), it seeds the snapshot from the latest checkpoint at or
+// before at and only replays the event tail after it, instead of the full
+// log. It periodically checks ctx.Err() while replaying so a slow client
+// disconnecting doesn't tie up the server once the log grows large.
+func (s *InventoryService) GetInventorySnapshot(ctx context.Context, at time.Time) (map[string]int, error) {
+	if err := s.acquireMutex(ctx); err != nil {
+		return nil, err
+	}
 	defer s.Mutex.Unlock()
 
-	// Initialize snapshot with zero counts
 	snapshot := make(map[string]int)
+	since := time.Time{}
+	if s.Snapshots != nil {
+		if cp, ok := s.Snapshots.Latest(at); ok {
+			for key, item := range cp.Items {
+				snapshot[key] = item.Count
+			}
+			since = cp.Timestamp
+		}
+	}
+
 	for key := range s.Items {
-		snapshot[key] = 0
+		if _, exists := snapshot[key]; !exists {
+			snapshot[key] = 0
+		}
 	}
 
-	// Replay events up to the specified timestamp
-	for _, event := range s.Events {
-		if event.Timestamp.After(at) {
+	// Replay the event tail strictly after the checkpoint, up to the
+	// specified timestamp.
+	for i, event := range s.Events {
+		if i%snapshotCtxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if !event.Timestamp.After(since) || event.Timestamp.After(at) {
 			continue
 		}
 		key := fmt.Sprintf("%s:%s", event.ItemID, event.WarehouseID)
 		snapshot[key] += event.Amount
 	}
 
-	return snapshot
+	return snapshot, nil
+}
+
+// ErrEventsCompacted is returned by EventsSince when since predates the
+// service's event horizon: events that old were folded into a checkpoint
+// and are no longer retained in Events, so returning a list would silently
+// omit them instead of reporting the gap.
+type ErrEventsCompacted struct {
+	Since   time.Time
+	Horizon time.Time
+}
+
+func (e *ErrEventsCompacted) Error() string {
+	return fmt.Sprintf("events since %s are no longer available: compacted into a checkpoint as of %s", e.Since.Format(time.RFC3339), e.Horizon.Format(time.RFC3339))
+}
+
+// EventsSince returns, in chronological order, all events recorded strictly
+// after since, along with the high-water timestamp the caller should pass as
+// since on its next call. The high-water mark is the timestamp of the last
+// event returned (or since unchanged if none were), computed under the same
+// lock as the scan itself, so a poll can never advance past an event still
+// being recorded by a concurrent mutation. If since predates the service's
+// event horizon (the most recent checkpoint compaction), it returns
+// ErrEventsCompacted instead of a silently incomplete list; the caller
+// should fall back to GetInventorySnapshot.
+func (s *InventoryService) EventsSince(since time.Time) ([]Event, time.Time, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if !s.eventHorizon.IsZero() && since.Before(s.eventHorizon) {
+		return nil, time.Time{}, &ErrEventsCompacted{Since: since, Horizon: s.eventHorizon}
+	}
+
+	events := make([]Event, 0)
+	highWater := since
+	for _, event := range s.Events {
+		if event.Timestamp.After(since) {
+			events = append(events, event)
+			if event.Timestamp.After(highWater) {
+				highWater = event.Timestamp
+			}
+		}
+	}
+	return events, highWater, nil
+}
+
+// ApplyEvents applies a batch of externally generated events (e.g. from
+// another inventory service instance or an offline client) to Items,
+// skipping any event whose EventID has already been seen so replaying the
+// same batch twice is safe. It returns the number of events actually
+// applied.
+func (s *InventoryService) ApplyEvents(events []Event) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	applied := 0
+	for _, event := range events {
+		if !s.recordEvent(event) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s:%s", event.ItemID, event.WarehouseID)
+		item, exists := s.Items[key]
+		if !exists {
+			item = &InventoryItem{
+				ItemID:      event.ItemID,
+				WarehouseID: event.WarehouseID,
+				Holds:       make(map[string]Hold),
+			}
+			s.Items[key] = item
+		}
+
+		item.Mutex.Lock()
+		item.Count += event.Amount
+		item.Version++
+		item.Mutex.Unlock()
+
+		applied++
+	}
+	return applied
+}
+
+// maxGuaranteedUpdateAttempts bounds the compare-and-swap retry loop in
+// GuaranteedUpdate.
+const maxGuaranteedUpdateAttempts = 3
+
+// ErrConflict is returned by GuaranteedUpdate when the expected version
+// never matched the stored item's version within maxGuaranteedUpdateAttempts
+// retries.
+type ErrConflict struct {
+	ItemID      string
+	WarehouseID string
+}
+
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("conflict updating %s:%s: version mismatch", e.ItemID, e.WarehouseID)
+}
+
+// GuaranteedUpdate performs a compare-and-swap update of an inventory item.
+// It reads the current item, invokes tryUpdate to compute the new state, and
+// commits only if the item's Version still matches expectedVersion. On a
+// mismatch it re-reads the fresh item, updates expectedVersion, and retries
+// tryUpdate, up to maxGuaranteedUpdateAttempts times, before giving up with
+// ErrConflict. This lets concurrent writers (receiving stock, manual
+// adjustments) cooperate without holding the service-wide mutex across
+// tryUpdate's execution. On a successful commit it records a
+// EventTypeCountAdjusted event, so the change is reflected by
+// GetInventorySnapshot and replicated via EventsSince/sync like every other
+// mutation.
+func (s *InventoryService) GuaranteedUpdate(itemID, warehouseID string, expectedVersion uint64, tryUpdate func(cur inventoryItemWire) (inventoryItemWire, error)) error {
+	key := fmt.Sprintf("%s:%s", itemID, warehouseID)
+
+	for attempt := 0; attempt < maxGuaranteedUpdateAttempts; attempt++ {
+		s.Mutex.Lock()
+		item, exists := s.Items[key]
+		s.Mutex.Unlock()
+		if !exists {
+			return fmt.Errorf("item not found")
+		}
+
+		item.Mutex.Lock()
+		cur := inventoryItemWire{
+			ItemID:      item.ItemID,
+			WarehouseID: item.WarehouseID,
+			Count:       item.Count,
+			Version:     item.Version,
+			Holds:       copyHolds(item.Holds),
+		}
+		item.Mutex.Unlock()
+
+		if cur.Version != expectedVersion {
+			expectedVersion = cur.Version
+			continue
+		}
+
+		updated, err := tryUpdate(cur)
+		if err != nil {
+			return err
+		}
+
+		item.Mutex.Lock()
+		if item.Version != expectedVersion {
+			newVersion := item.Version
+			item.Mutex.Unlock()
+			expectedVersion = newVersion
+			continue
+		}
+		delta := updated.Count - item.Count
+		item.Count = updated.Count
+		item.Version++
+		item.Mutex.Unlock()
+
+		s.Mutex.Lock()
+		s.recordEvent(Event{
+			EventID:     uuid.New().String(),
+			Type:        EventTypeCountAdjusted,
+			ItemID:      itemID,
+			WarehouseID: warehouseID,
+			Amount:      delta,
+			Timestamp:   time.Now(),
+		})
+		s.Mutex.Unlock()
+
+		return nil
+	}
+
+	return &ErrConflict{ItemID: itemID, WarehouseID: warehouseID}
+}
+
+// Router wraps http.ServeMux with a prometheus.Registry, exposing /metrics
+// and /healthz for free and centralizing request logging and JSON-encoding
+// error handling for every other handler registered through it.
+type Router struct {
+	mux      *http.ServeMux
+	registry *prometheus.Registry
+
+	requestDuration *prometheus.HistogramVec
+}
+
+// NewRouter creates a Router with /metrics and /healthz already wired up.
+// healthz reports service's event-log length and item count.
+func NewRouter(service *InventoryService) *Router {
+	registry := prometheus.NewRegistry()
+
+	requestDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "inventory_http_request_duration_seconds",
+		Help: "Latency of inventory service HTTP handlers, labeled by path.",
+	}, []string{"path"})
+	registry.MustRegister(requestDuration)
+
+	router := &Router{
+		mux:             http.NewServeMux(),
+		registry:        registry,
+		requestDuration: requestDuration,
+	}
+
+	router.mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	router.mux.HandleFunc("/healthz", router.healthzHandler(service))
+
+	return router
+}
+
+// Registry returns the router's prometheus.Registry, so collectors created
+// elsewhere (e.g. Metrics) can be registered against the same registry
+// /metrics serves.
+func (router *Router) Registry() *prometheus.Registry {
+	return router.registry
+}
+
+// Handle registers handler at path, wrapping it with request logging and
+// latency instrumentation.
+func (router *Router) Handle(path string, handler http.HandlerFunc) {
+	router.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		log.Printf("%s %s", r.Method, r.URL.Path)
+
+		handler(w, r)
+
+		router.requestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	router.mux.ServeHTTP(w, r)
+}
+
+func (router *Router) healthzHandler(service *InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		service.Mutex.Lock()
+		resp := map[string]int{
+			"event_log_length": len(service.Events),
+			"item_count":       len(service.Items),
+		}
+		service.Mutex.Unlock()
+
+		writeJSON(w, resp)
+	}
+}
+
+// writeJSON encodes v as the response body, logging (instead of silently
+// swallowing, as the handlers below used to) any encoding failure.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode JSON response: %v", err)
+	}
 }
 
 // HTTP Handlers for API endpoints
 
 func (s *InventoryService) getInventorySnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.RequestTimeout)
+	defer cancel()
+
 	// Parse the 'at' query parameter as a timestamp
 	atParam := r.URL.Query().Get("at")
 	var atTime time.Time
@@ -191,32 +1039,44 @@ func (s *InventoryService) getInventorySnapshotHandler(w http.ResponseWriter, r
 		atTime = time.Now()
 	}
 
-	snapshot := s.GetInventorySnapshot(atTime)
-	json.NewEncoder(w).Encode(snapshot)
+	snapshot, err := s.GetInventorySnapshot(ctx, atTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+	writeJSON(w, snapshot)
 }
 
 func (s *InventoryService) placeHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.RequestTimeout)
+	defer cancel()
+
 	var req struct {
 		ItemID      string `json:"item_id"`
 		WarehouseID string `json:"warehouse_id"`
 		Amount      int    `json:"amount"`
+		TTLSeconds  int    `json:"ttl_seconds"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	holdID, err := s.PlaceHold(req.ItemID, req.WarehouseID, req.Amount)
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	holdID, err := s.PlaceHold(ctx, req.ItemID, req.WarehouseID, req.Amount, ttl)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
 	resp := map[string]string{"hold_id": holdID}
-	json.NewEncoder(w).Encode(resp)
+	writeJSON(w, resp)
 }
 
 func (s *InventoryService) executeHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.RequestTimeout)
+	defer cancel()
+
 	var req struct {
 		ItemID      string `json:"item_id"`
 		WarehouseID string `json:"warehouse_id"`
@@ -227,7 +1087,7 @@ func (s *InventoryService) executeHoldHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err := s.ExecuteHold(req.ItemID, req.WarehouseID, req.HoldID)
+	err := s.ExecuteHold(ctx, req.ItemID, req.WarehouseID, req.HoldID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -237,51 +1097,197 @@ func (s *InventoryService) executeHoldHandler(w http.ResponseWriter, r *http.Req
 	w.Write([]byte("Hold executed successfully"))
 }
 
-func main() {
-	inventoryService := NewInventoryService()
+func (s *InventoryService) refreshHoldHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.RequestTimeout)
+	defer cancel()
 
-	// Initialize inventory with some items
-	inventoryService.Items["ABC123:WH1"] = &InventoryItem{
-		ItemID:      "ABC123",
-		WarehouseID: "WH1",
-		Count:       100,
-		Holds:       make(map[string]Hold),
+	var req struct {
+		ItemID      string `json:"item_id"`
+		WarehouseID string `json:"warehouse_id"`
+		HoldID      string `json:"hold_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	inventoryService.Items["DEF456:WH2"] = &InventoryItem{
-		ItemID:      "DEF456",
-		WarehouseID: "WH2",
-		Count:       200,
-		Holds:       make(map[string]Hold),
+	if err := s.RefreshHold(ctx, req.ItemID, req.WarehouseID, req.HoldID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
-	// Initialize inventory with initial events
-	ts, err := time.Parse(time.RFC3339, "2023-10-01T15:09:59Z")
-	if err != nil {
-		fmt.Println("Error parsing timestamp:", err)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Hold refreshed successfully"))
+}
+
+// syncPullResponse is returned by GET /sync.
+type syncPullResponse struct {
+	Events        []Event   `json:"events"`
+	SyncTimestamp time.Time `json:"sync_timestamp"`
+}
+
+// syncPushRequest is the body accepted by POST /sync.
+type syncPushRequest struct {
+	Events []Event `json:"events"`
+}
+
+// syncHandler backs GET/POST /sync: GET pulls events newer than `since` for
+// replication to another node or an offline client, POST pushes a batch of
+// externally generated events to be applied idempotently.
+func (s *InventoryService) syncHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sinceParam := r.URL.Query().Get("since")
+		var since time.Time
+		if sinceParam != "" {
+			parsed, err := time.Parse(time.RFC3339, sinceParam)
+			if err != nil {
+				http.Error(w, "Invalid 'since' timestamp", http.StatusBadRequest)
+				return
+			}
+			since = parsed
+		}
+
+		events, highWater, err := s.EventsSince(since)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		resp := syncPullResponse{
+			Events:        events,
+			SyncTimestamp: highWater,
+		}
+		writeJSON(w, resp)
+
+	case http.MethodPost:
+		var req syncPushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		applied := s.ApplyEvents(req.Events)
+		writeJSON(w, map[string]int{"applied": applied})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *InventoryService) updateCountHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ItemID          string `json:"item_id"`
+		WarehouseID     string `json:"warehouse_id"`
+		ExpectedVersion uint64 `json:"expected_version"`
+		Delta           int    `json:"delta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	inventoryService.Events = append(inventoryService.Events, Event{
-		EventID:     "initial-event-1",
-		ItemID:      "ABC123",
-		WarehouseID: "WH1",
-		Amount:      100,
-		Timestamp:   ts,
-	})
 
-	inventoryService.Events = append(inventoryService.Events, Event{
-		EventID:     "initial-event-2",
-		ItemID:      "DEF456",
-		WarehouseID: "WH2",
-		Amount:      200,
-		Timestamp:   ts,
+	err := s.GuaranteedUpdate(req.ItemID, req.WarehouseID, req.ExpectedVersion, func(cur inventoryItemWire) (inventoryItemWire, error) {
+		if cur.Count+req.Delta < 0 {
+			return cur, fmt.Errorf("insufficient inventory")
+		}
+		cur.Count += req.Delta
+		return cur, nil
 	})
+	if err != nil {
+		var conflict *ErrConflict
+		if errors.As(err, &conflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Count updated successfully"))
+}
+
+// snapshotFilePath is where the checkpoint/event-log backend persists state
+// across restarts.
+const snapshotFilePath = "inventory-snapshots.gob"
+
+// checkpointEveryEvents and checkpointInterval control how often
+// InventoryService takes a checkpoint once snapshotting is enabled.
+const (
+	checkpointEveryEvents = 100
+	checkpointInterval    = 5 * time.Minute
+)
+
+func main() {
+	inventoryService := NewInventoryService()
+
+	snapshotFile, err := os.OpenFile(snapshotFilePath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		fmt.Println("Error opening snapshot file:", err)
+		return
+	}
+	defer snapshotFile.Close()
+
+	// EnableSnapshots before Restore: Restore reads the checkpoint/tail
+	// already on disk through the same store, and subsequent writes append
+	// from wherever that read left the file's offset.
+	inventoryService.EnableSnapshots(NewSnapshotStore(snapshotFile), checkpointEveryEvents, checkpointInterval)
+	if err := inventoryService.Restore(snapshotFile); err != nil {
+		fmt.Println("Error restoring from snapshot store:", err)
+		return
+	}
+
+	if len(inventoryService.Items) == 0 {
+		// Fresh start: nothing to restore, so seed some demo inventory.
+		inventoryService.Items["ABC123:WH1"] = &InventoryItem{
+			ItemID:      "ABC123",
+			WarehouseID: "WH1",
+			Count:       100,
+			Holds:       make(map[string]Hold),
+		}
+
+		inventoryService.Items["DEF456:WH2"] = &InventoryItem{
+			ItemID:      "DEF456",
+			WarehouseID: "WH2",
+			Count:       200,
+			Holds:       make(map[string]Hold),
+		}
+
+		ts, err := time.Parse(time.RFC3339, "2023-10-01T15:09:59Z")
+		if err != nil {
+			fmt.Println("Error parsing timestamp:", err)
+			return
+		}
+		inventoryService.Mutex.Lock()
+		inventoryService.recordEvent(Event{
+			EventID:     "initial-event-1",
+			ItemID:      "ABC123",
+			WarehouseID: "WH1",
+			Amount:      100,
+			Timestamp:   ts,
+		})
+		inventoryService.recordEvent(Event{
+			EventID:     "initial-event-2",
+			ItemID:      "DEF456",
+			WarehouseID: "WH2",
+			Amount:      200,
+			Timestamp:   ts,
+		})
+		inventoryService.Mutex.Unlock()
+	}
+
+	// Set up HTTP routes behind a Router so /metrics, /healthz, logging and
+	// latency instrumentation apply uniformly.
+	router := NewRouter(inventoryService)
+	inventoryService.EnableMetrics(NewMetrics(router.Registry()))
 
-	// Set up HTTP routes
-	http.HandleFunc("/place_hold", inventoryService.placeHoldHandler)
-	http.HandleFunc("/execute_hold", inventoryService.executeHoldHandler)
-	http.HandleFunc("/inventory_snapshot", inventoryService.getInventorySnapshotHandler)
+	router.Handle("/place_hold", inventoryService.placeHoldHandler)
+	router.Handle("/execute_hold", inventoryService.executeHoldHandler)
+	router.Handle("/refresh_hold", inventoryService.refreshHoldHandler)
+	router.Handle("/inventory_snapshot", inventoryService.getInventorySnapshotHandler)
+	router.Handle("/sync", inventoryService.syncHandler)
+	router.Handle("/update_count", inventoryService.updateCountHandler)
 
 	fmt.Println("Inventory service is running on port 8080")
-	http.ListenAndServe(":8080", nil)
+	http.ListenAndServe(":8080", router)
 }
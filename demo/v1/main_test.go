@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestGuaranteedUpdateRetriesOnConflict(t *testing.T) {
+	s := NewInventoryService()
+	item := &InventoryItem{ItemID: "ITEM", WarehouseID: "WH", Count: 10, Holds: make(map[string]Hold)}
+	s.Items["ITEM:WH"] = item
+
+	attempts := 0
+	err := s.GuaranteedUpdate("ITEM", "WH", item.Version, func(cur inventoryItemWire) (inventoryItemWire, error) {
+		attempts++
+		if attempts == 1 {
+			// Simulate a concurrent writer bumping the version between our
+			// read and commit, forcing a retry.
+			item.Mutex.Lock()
+			item.Version++
+			item.Mutex.Unlock()
+		}
+		cur.Count += 5
+		return cur, nil
+	})
+	if err != nil {
+		t.Fatalf("GuaranteedUpdate: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected tryUpdate to run twice, got %d", attempts)
+	}
+	if item.Count != 15 {
+		t.Fatalf("expected Count 15, got %d", item.Count)
+	}
+	if len(s.Events) != 1 {
+		t.Fatalf("expected one recorded event, got %d", len(s.Events))
+	}
+	if got := s.Events[0]; got.Type != EventTypeCountAdjusted || got.Amount != 5 {
+		t.Fatalf("expected a count_adjusted event with amount 5, got %+v", got)
+	}
+}
+
+func TestGuaranteedUpdateGivesUpAfterMaxAttempts(t *testing.T) {
+	s := NewInventoryService()
+	item := &InventoryItem{ItemID: "ITEM", WarehouseID: "WH", Count: 10, Holds: make(map[string]Hold)}
+	s.Items["ITEM:WH"] = item
+
+	err := s.GuaranteedUpdate("ITEM", "WH", item.Version, func(cur inventoryItemWire) (inventoryItemWire, error) {
+		// Always bump the version so every attempt loses the race.
+		item.Mutex.Lock()
+		item.Version++
+		item.Mutex.Unlock()
+		cur.Count++
+		return cur, nil
+	})
+
+	var conflict *ErrConflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if len(s.Events) != 0 {
+		t.Fatalf("expected no event recorded on a failed update, got %+v", s.Events)
+	}
+	if item.Count != 10 {
+		t.Fatalf("expected Count unchanged at 10, got %d", item.Count)
+	}
+}
+
+func TestSnapshotStoreSaveAndLoadRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	store := NewSnapshotStore(&buf)
+
+	cp := Checkpoint{
+		Timestamp: time.Unix(1000, 0).UTC(),
+		Items: map[string]inventoryItemWire{
+			"ABC123:WH1": {ItemID: "ABC123", WarehouseID: "WH1", Count: 42, Version: 3, Holds: map[string]Hold{}},
+		},
+	}
+	if err := store.Save(cp); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	tailEvent := Event{
+		EventID:     "e1",
+		Type:        EventTypeHoldPlaced,
+		ItemID:      "ABC123",
+		WarehouseID: "WH1",
+		Amount:      -5,
+		Timestamp:   time.Unix(1001, 0).UTC(),
+	}
+	if err := store.AppendEvent(tailEvent); err != nil {
+		t.Fatalf("AppendEvent: %v", err)
+	}
+
+	restored, tail, err := NewSnapshotStore(io.Discard).Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !restored.Timestamp.Equal(cp.Timestamp) {
+		t.Fatalf("expected checkpoint timestamp %v, got %v", cp.Timestamp, restored.Timestamp)
+	}
+	if got := restored.Items["ABC123:WH1"].Count; got != 42 {
+		t.Fatalf("expected restored count 42, got %d", got)
+	}
+	if len(tail) != 1 || tail[0].EventID != "e1" {
+		t.Fatalf("expected tail [e1], got %+v", tail)
+	}
+}
+
+func TestInventoryServiceRestoreSeedsItemsAndEventHorizon(t *testing.T) {
+	var buf bytes.Buffer
+
+	seed := NewInventoryService()
+	item := &InventoryItem{ItemID: "ABC123", WarehouseID: "WH1", Count: 100, Holds: make(map[string]Hold)}
+	seed.Items["ABC123:WH1"] = item
+	seed.EnableSnapshots(NewSnapshotStore(&buf), 0, 0)
+
+	seed.Mutex.Lock()
+	item.Count -= 10 // mirrors what PlaceHold does alongside recordEvent
+	seed.recordEvent(Event{
+		EventID:     "e1",
+		Type:        EventTypeHoldPlaced,
+		ItemID:      "ABC123",
+		WarehouseID: "WH1",
+		Amount:      -10,
+		Timestamp:   time.Unix(1000, 0).UTC(),
+	})
+	if err := seed.checkpoint(); err != nil {
+		seed.Mutex.Unlock()
+		t.Fatalf("checkpoint: %v", err)
+	}
+	seed.Mutex.Unlock()
+
+	restored := NewInventoryService()
+	restored.EnableSnapshots(NewSnapshotStore(io.Discard), 0, 0)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if got := restored.Items["ABC123:WH1"].Count; got != 90 {
+		t.Fatalf("expected restored count 90, got %d", got)
+	}
+
+	var compacted *ErrEventsCompacted
+	if _, _, err := restored.EventsSince(time.Unix(0, 0).UTC()); !errors.As(err, &compacted) {
+		t.Fatalf("expected ErrEventsCompacted for a since before the restored checkpoint, got %v", err)
+	}
+}